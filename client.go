@@ -0,0 +1,279 @@
+/*
+client.go implements the client half of the RFB protocol handshake
+described in RFC 6143 §7.1-§7.4: the ProtocolVersion exchange, security
+negotiation, and the initialization messages, followed by a read loop
+that dispatches ServerMessages. It is the client-side counterpart to
+ServerConn.
+
+See http://tools.ietf.org/html/rfc6143 for more info.
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"net"
+)
+
+// ClientConfig configures the handshake and initial state of a ClientConn
+// established via Connect.
+type ClientConfig struct {
+	// Auth is the list of security types this client is willing to use,
+	// in order of preference. At least one must be supplied.
+	Auth []ClientAuth
+
+	// Exclusive requests exclusive access to the server's framebuffer,
+	// per the shared-flag byte of ClientInit (RFC 6143 §7.3.1).
+	Exclusive bool
+
+	// Encodings are offered to the server via SetEncodings, in order of
+	// preference; Raw is always implicitly supported.
+	Encodings []Encoding
+
+	// ServerMessageCh, if non-nil, receives every ServerMessage decoded
+	// from the connection once Serve's read loop is running.
+	ServerMessageCh chan ServerMessage
+
+	// Recorder, if non-nil, is attached to the connection before
+	// ClientInit/ServerInit run, so it captures the entire session
+	// including the initialization messages.
+	Recorder *Recorder
+}
+
+// ClientConn holds the state for a single VNC connection established via
+// Connect. It is the client-side counterpart to ServerConn.
+type ClientConn struct {
+	c      net.Conn
+	config *ClientConfig
+
+	colorMap    [256]Color
+	encodings   []Encoding
+	PixelFormat PixelFormat
+
+	FrameBufferWidth, FrameBufferHeight uint16
+	desktopName                         string
+
+	frameBuffer      *image.RGBA
+	cursor           Cursor
+	zlibReaders      [zlibStreamCount]io.Reader
+	zlibChunkReaders [zlibStreamCount]*zlibChunkReader
+
+	extensions        map[int32]Extension
+	extensionMessages map[uint8]ServerMessage
+	enabledExtensions map[int32]bool
+
+	recorder *Recorder
+	// reader is the current read seam for all server-to-client bytes: the
+	// raw connection until a Recorder is attached, at which point it
+	// becomes a TeeReader so every byte read through it -- ServerInit,
+	// message headers, and encoding payloads alike -- is captured.
+	reader io.Reader
+
+	debug bool
+}
+
+// Connect performs the client side of the RFB handshake on c and returns
+// the resulting ClientConn. The caller is responsible for calling Serve
+// to start dispatching ServerMessages.
+func Connect(c net.Conn, cfg *ClientConfig) (*ClientConn, error) {
+	if len(cfg.Auth) == 0 {
+		return nil, NewVNCError("Connect: no security types configured")
+	}
+
+	cc := &ClientConn{
+		c:         c,
+		config:    cfg,
+		encodings: cfg.Encodings,
+	}
+
+	if err := cc.protocolVersionHandshake(); err != nil {
+		return nil, err
+	}
+	if err := cc.securityHandshake(); err != nil {
+		return nil, err
+	}
+
+	cc.reader = cc.c
+	if cfg.Recorder != nil {
+		if err := cfg.Recorder.Attach(cc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cc.clientInit(); err != nil {
+		return nil, err
+	}
+	if err := cc.serverInit(); err != nil {
+		return nil, err
+	}
+
+	return cc, nil
+}
+
+// protocolVersionHandshake implements RFC 6143 §7.1.1.
+func (c *ClientConn) protocolVersionHandshake() error {
+	var version [12]byte
+	if _, err := io.ReadFull(c.c, version[:]); err != nil {
+		return err
+	}
+
+	_, err := c.c.Write([]byte("RFB 003.008\n"))
+	return err
+}
+
+// securityHandshake implements RFC 6143 §7.1.2: the server advertises its
+// security types, the client picks the first of config.Auth it also
+// offers, and that type's ClientAuth runs the remainder of the
+// authentication exchange.
+func (c *ClientConn) securityHandshake() error {
+	var numTypes uint8
+	if err := binary.Read(c.c, binary.BigEndian, &numTypes); err != nil {
+		return err
+	}
+	offered := make([]uint8, numTypes)
+	if err := binary.Read(c.c, binary.BigEndian, &offered); err != nil {
+		return err
+	}
+
+	var auth ClientAuth
+	for _, want := range c.config.Auth {
+		for _, have := range offered {
+			if want.SecurityType() == have {
+				auth = want
+				break
+			}
+		}
+		if auth != nil {
+			break
+		}
+	}
+	if auth == nil {
+		return NewVNCError("securityHandshake: no common security type with server")
+	}
+
+	if err := binary.Write(c.c, binary.BigEndian, auth.SecurityType()); err != nil {
+		return err
+	}
+
+	conn, err := auth.Handshake(c.c)
+	if err != nil {
+		return err
+	}
+	c.c = conn
+
+	var result uint32
+	if err := binary.Read(c.c, binary.BigEndian, &result); err != nil {
+		return err
+	}
+	if result != 0 {
+		var reasonLength uint32
+		if err := binary.Read(c.c, binary.BigEndian, &reasonLength); err != nil {
+			return err
+		}
+		reason := make([]byte, reasonLength)
+		if _, err := io.ReadFull(c.c, reason); err != nil {
+			return err
+		}
+		return NewVNCError(fmt.Sprintf("securityHandshake: %s", reason))
+	}
+
+	return nil
+}
+
+// clientInit implements RFC 6143 §7.3.1.
+func (c *ClientConn) clientInit() error {
+	shared := uint8(1)
+	if c.config.Exclusive {
+		shared = 0
+	}
+	return binary.Write(c.c, binary.BigEndian, shared)
+}
+
+// serverInit implements RFC 6143 §7.3.2.
+func (c *ClientConn) serverInit() error {
+	if err := binary.Read(c.reader, binary.BigEndian, &c.FrameBufferWidth); err != nil {
+		return err
+	}
+	if err := binary.Read(c.reader, binary.BigEndian, &c.FrameBufferHeight); err != nil {
+		return err
+	}
+	if err := binary.Read(c.reader, binary.BigEndian, &c.PixelFormat); err != nil {
+		return err
+	}
+
+	var nameLength uint32
+	if err := binary.Read(c.reader, binary.BigEndian, &nameLength); err != nil {
+		return err
+	}
+	name := make([]byte, nameLength)
+	if _, err := io.ReadFull(c.reader, name); err != nil {
+		return err
+	}
+	c.desktopName = string(name)
+
+	return nil
+}
+
+// serverMessages are the ServerMessage types this ClientConn knows how to
+// decode, keyed by wire type. Extensions register additional entries via
+// RegisterExtensionMessage.
+var serverMessages = map[uint8]ServerMessage{
+	FramebufferUpdateMsg:  new(FramebufferUpdate),
+	SetColorMapEntriesMsg: new(SetColorMapEntries),
+	BellMsg:               new(Bell),
+	ServerCutTextMsg:      new(ServerCutText),
+}
+
+// Serve runs the read loop for c, decoding ServerMessages off the wire and
+// delivering them to c.config.ServerMessageCh until the connection errs or
+// is closed.
+func (c *ClientConn) Serve() error {
+	defer c.c.Close()
+
+	for {
+		var messageType uint8
+		if err := c.receive(&messageType); err != nil {
+			return err
+		}
+
+		proto, ok := serverMessages[messageType]
+		if !ok {
+			proto, ok = c.extensionMessages[messageType]
+			if !ok {
+				return fmt.Errorf("Serve: unsupported message type: %d", messageType)
+			}
+		}
+
+		msg, err := proto.Read(c, c.reader)
+		if err != nil {
+			return err
+		}
+
+		if c.config.ServerMessageCh != nil {
+			c.config.ServerMessageCh <- msg
+		}
+	}
+}
+
+// Encodings returns the encodings c was configured with.
+func (c *ClientConn) Encodings() []Encoding {
+	return c.encodings
+}
+
+// Close closes the underlying connection.
+func (c *ClientConn) Close() error {
+	return c.c.Close()
+}
+
+// receive reads data off the wire in big-endian order, via c.reader so a
+// Recorder attached to the connection sees these bytes too.
+func (c *ClientConn) receive(data interface{}) error {
+	return binary.Read(c.reader, binary.BigEndian, data)
+}
+
+// send writes data to the wire in big-endian order.
+func (c *ClientConn) send(data interface{}) error {
+	return binary.Write(c.c, binary.BigEndian, data)
+}
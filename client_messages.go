@@ -0,0 +1,203 @@
+/*
+client_messages.go implements RFC 6143 §7.5 Client-to-Server Messages.
+See http://tools.ietf.org/html/rfc6143#section-7.5 for more info.
+
+These are read by a ServerConn; a ClientConn only ever writes them.
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	SetPixelFormatMsg = uint8(iota)
+	_                 // FixColourMapEntries, never sent by clients in practice.
+	SetEncodingsMsg
+	FramebufferUpdateRequestMsg
+	KeyEventMsg
+	PointerEventMsg
+	ClientCutTextMsg
+)
+
+// A ClientMessage implements a message sent from the client to the server.
+type ClientMessage interface {
+	// The type of the message that is sent down on the wire.
+	Type() uint8
+
+	// Read reads the contents of the message from the reader. At the point
+	// this is called, the message type has already been read from the
+	// reader. This should return a new ClientMessage that is the
+	// appropriate type.
+	Read(*ServerConn, io.Reader) (ClientMessage, error)
+}
+
+// SetPixelFormat sets the format in which pixel values should be sent in
+// FramebufferUpdate messages from the server to this client.
+//
+// See RFC 6143 Section 7.5.1
+type SetPixelFormat struct {
+	PF PixelFormat
+}
+
+func (*SetPixelFormat) Type() uint8 { return SetPixelFormatMsg }
+
+func (*SetPixelFormat) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var padding [3]byte
+	if err := binary.Read(r, binary.BigEndian, &padding); err != nil {
+		return nil, err
+	}
+
+	var pf PixelFormat
+	if err := binary.Read(r, binary.BigEndian, &pf); err != nil {
+		return nil, err
+	}
+
+	return &SetPixelFormat{pf}, nil
+}
+
+// SetEncodings sets the encoding types that the client can decode, in order
+// of preference.
+//
+// See RFC 6143 Section 7.5.2
+type SetEncodings struct {
+	Encodings []int32
+}
+
+func (*SetEncodings) Type() uint8 { return SetEncodingsMsg }
+
+func (*SetEncodings) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var padding [1]byte
+	if err := binary.Read(r, binary.BigEndian, &padding); err != nil {
+		return nil, err
+	}
+
+	var numEncodings uint16
+	if err := binary.Read(r, binary.BigEndian, &numEncodings); err != nil {
+		return nil, err
+	}
+
+	encodings := make([]int32, numEncodings)
+	for i := uint16(0); i < numEncodings; i++ {
+		if err := binary.Read(r, binary.BigEndian, &encodings[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &SetEncodings{encodings}, nil
+}
+
+// SendSetEncodings sends a SetEncodings message listing c's configured
+// Encodings, in preference order, with the pseudo-encoding of every
+// registered extension appended so the server knows to enable them.
+func (c *ClientConn) SendSetEncodings() error {
+	encodings := make([]int32, 0, len(c.encodings)+len(c.extensions))
+	for _, enc := range c.encodings {
+		encodings = append(encodings, enc.Type())
+	}
+	encodings = append(encodings, c.extensionEncodings()...)
+
+	msg := struct {
+		Type    uint8
+		Padding [1]byte
+		Count   uint16
+	}{SetEncodingsMsg, [1]byte{}, uint16(len(encodings))}
+	if err := c.send(msg); err != nil {
+		return err
+	}
+
+	for _, enc := range encodings {
+		if err := c.send(enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FramebufferUpdateRequest requests the server send a FramebufferUpdate.
+//
+// See RFC 6143 Section 7.5.3
+type FramebufferUpdateRequest struct {
+	Incremental   uint8
+	X, Y          uint16
+	Width, Height uint16
+}
+
+func (*FramebufferUpdateRequest) Type() uint8 { return FramebufferUpdateRequestMsg }
+
+func (*FramebufferUpdateRequest) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var req FramebufferUpdateRequest
+	if err := binary.Read(r, binary.BigEndian, &req); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// KeyEvent indicates a key press or release.
+//
+// See RFC 6143 Section 7.5.4
+type KeyEvent struct {
+	Down   uint8
+	_      [2]byte // padding
+	Keysym uint32
+}
+
+func (*KeyEvent) Type() uint8 { return KeyEventMsg }
+
+func (*KeyEvent) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var evt KeyEvent
+	if err := binary.Read(r, binary.BigEndian, &evt); err != nil {
+		return nil, err
+	}
+
+	return &evt, nil
+}
+
+// PointerEvent indicates pointer movement or a button press/release.
+//
+// See RFC 6143 Section 7.5.5
+type PointerEvent struct {
+	Mask uint8
+	X, Y uint16
+}
+
+func (*PointerEvent) Type() uint8 { return PointerEventMsg }
+
+func (*PointerEvent) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var evt PointerEvent
+	if err := binary.Read(r, binary.BigEndian, &evt); err != nil {
+		return nil, err
+	}
+
+	return &evt, nil
+}
+
+// ClientCutText indicates the client has new text in its cut buffer.
+//
+// See RFC 6143 Section 7.5.6
+type ClientCutText struct {
+	Text string
+}
+
+func (*ClientCutText) Type() uint8 { return ClientCutTextMsg }
+
+func (*ClientCutText) Read(c *ServerConn, r io.Reader) (ClientMessage, error) {
+	var padding [3]byte
+	if err := binary.Read(r, binary.BigEndian, &padding); err != nil {
+		return nil, err
+	}
+
+	var textLength uint32
+	if err := binary.Read(r, binary.BigEndian, &textLength); err != nil {
+		return nil, err
+	}
+
+	textBytes := make([]uint8, textLength)
+	if err := binary.Read(r, binary.BigEndian, &textBytes); err != nil {
+		return nil, err
+	}
+
+	return &ClientCutText{string(textBytes)}, nil
+}
@@ -0,0 +1,601 @@
+/*
+encodings.go decodes the pixel data carried by FramebufferUpdate rectangles
+(RFC 6143 §7.7) into a shared framebuffer image, plus the common
+pseudo-encodings servers use to signal cursor and desktop-size changes.
+
+See http://tools.ietf.org/html/rfc6143#section-7.7 for more info.
+*/
+package vnc
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// Encoding-type numbers, as assigned by RFC 6143 §7.7 and the
+// pseudo-encoding extensions servers commonly advertise alongside them.
+const (
+	Raw      = int32(0)
+	CopyRect = int32(1)
+	RRE      = int32(2)
+	Hextile  = int32(5)
+	Tight    = int32(7)
+	ZRLE     = int32(16)
+
+	CursorPseudo      = int32(-239)
+	XCursorPseudo     = int32(-240)
+	DesktopSizePseudo = int32(-223)
+)
+
+// An Encoding implements a method for decoding pixel data for a single
+// rectangle of a FramebufferUpdate off the wire.
+type Encoding interface {
+	// Type is the wire value identifying this encoding in a
+	// RectangleMessage.
+	Type() int32
+
+	// Read decodes rect's pixel data from r, applying it to c's shared
+	// framebuffer (or, for pseudo-encodings, to other state on c). At
+	// the point this is called, rect's header (x, y, width, height,
+	// encoding-type) has already been read from the wire.
+	Read(c *ClientConn, rect *Rectangle, r io.Reader) error
+}
+
+// framebuffer lazily allocates and returns the shared *image.RGBA backing
+// store for c, sized to the negotiated framebuffer dimensions.
+func (c *ClientConn) framebuffer() *image.RGBA {
+	if c.frameBuffer == nil {
+		c.frameBuffer = image.NewRGBA(image.Rect(0, 0, int(c.FrameBufferWidth), int(c.FrameBufferHeight)))
+	}
+	return c.frameBuffer
+}
+
+// readColor reads a single pixel in c's negotiated PixelFormat from r and
+// returns it as a color.RGBA.
+func readColor(c *ClientConn, r io.Reader) (color.RGBA, error) {
+	pf := c.PixelFormat
+	buf := make([]byte, pf.BPP/8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return color.RGBA{}, err
+	}
+
+	var raw uint32
+	if pf.BigEndian != 0 {
+		for _, b := range buf {
+			raw = raw<<8 | uint32(b)
+		}
+	} else {
+		for i := len(buf) - 1; i >= 0; i-- {
+			raw = raw<<8 | uint32(buf[i])
+		}
+	}
+
+	scale := func(v, max uint32) uint8 {
+		if max == 0 {
+			return 0
+		}
+		return uint8(v * 255 / max)
+	}
+
+	r8 := scale((raw>>pf.RedShift)&uint32(pf.RedMax), uint32(pf.RedMax))
+	g8 := scale((raw>>pf.GreenShift)&uint32(pf.GreenMax), uint32(pf.GreenMax))
+	b8 := scale((raw>>pf.BlueShift)&uint32(pf.BlueMax), uint32(pf.BlueMax))
+
+	return color.RGBA{R: r8, G: g8, B: b8, A: 0xff}, nil
+}
+
+// RawEncoding is the mandatory fallback encoding: width*height pixels in
+// the client's negotiated PixelFormat, left to right, top to bottom, with
+// no compression.
+//
+// See RFC 6143 Section 7.7.1
+type RawEncoding struct {
+	Colors []Color
+}
+
+func NewRawEncoding(colors []Color) *RawEncoding {
+	return &RawEncoding{colors}
+}
+
+func (*RawEncoding) Type() int32 { return Raw }
+
+func (e *RawEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	fb := c.framebuffer()
+	for y := 0; y < int(rect.Height); y++ {
+		for x := 0; x < int(rect.Width); x++ {
+			col, err := readColor(c, r)
+			if err != nil {
+				return err
+			}
+			fb.SetRGBA(int(rect.X)+x, int(rect.Y)+y, col)
+		}
+	}
+	return nil
+}
+
+// CopyRectEncoding copies an existing rectangle of the framebuffer to a
+// new position.
+//
+// See RFC 6143 Section 7.7.2
+type CopyRectEncoding struct {
+	SrcX, SrcY uint16
+}
+
+func (*CopyRectEncoding) Type() int32 { return CopyRect }
+
+func (e *CopyRectEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	if err := binary.Read(r, binary.BigEndian, e); err != nil {
+		return err
+	}
+
+	fb := c.framebuffer()
+	src := image.Rect(int(e.SrcX), int(e.SrcY), int(e.SrcX)+int(rect.Width), int(e.SrcY)+int(rect.Height))
+	tmp := image.NewRGBA(src.Sub(src.Min))
+	draw.Draw(tmp, tmp.Bounds(), fb, src.Min, draw.Src)
+	dst := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	draw.Draw(fb, dst, tmp, image.Point{}, draw.Src)
+
+	return nil
+}
+
+// RREEncoding is Rise-and-Run-length Encoding: a background color plus a
+// list of foreground sub-rectangles.
+//
+// See RFC 6143 Section 7.7.3
+type RREEncoding struct{}
+
+func (*RREEncoding) Type() int32 { return RRE }
+
+func (*RREEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	var numSubrects uint32
+	if err := binary.Read(r, binary.BigEndian, &numSubrects); err != nil {
+		return err
+	}
+
+	bg, err := readColor(c, r)
+	if err != nil {
+		return err
+	}
+
+	fb := c.framebuffer()
+	fillRect(fb, int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height), bg)
+
+	for i := uint32(0); i < numSubrects; i++ {
+		fg, err := readColor(c, r)
+		if err != nil {
+			return err
+		}
+
+		var sub struct {
+			X, Y, W, H uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &sub); err != nil {
+			return err
+		}
+
+		fillRect(fb, int(rect.X)+int(sub.X), int(rect.Y)+int(sub.Y), int(sub.W), int(sub.H), fg)
+	}
+
+	return nil
+}
+
+// HextileEncoding divides the rectangle into 16x16 tiles, each carrying
+// its own background/foreground colors and, optionally, sub-rectangles.
+//
+// See RFC 6143 Section 7.7.4
+type HextileEncoding struct{}
+
+const (
+	hextileRaw                 = 1 << 0
+	hextileBackgroundSpecified = 1 << 1
+	hextileForegroundSpecified = 1 << 2
+	hextileAnySubrects         = 1 << 3
+	hextileSubrectsColoured    = 1 << 4
+)
+
+func (*HextileEncoding) Type() int32 { return Hextile }
+
+func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	fb := c.framebuffer()
+	var bg, fg color.RGBA
+
+	for ty := 0; ty < int(rect.Height); ty += 16 {
+		th := min(16, int(rect.Height)-ty)
+		for tx := 0; tx < int(rect.Width); tx += 16 {
+			tw := min(16, int(rect.Width)-tx)
+
+			var subEncoding uint8
+			if err := binary.Read(r, binary.BigEndian, &subEncoding); err != nil {
+				return err
+			}
+
+			if subEncoding&hextileRaw != 0 {
+				for y := 0; y < th; y++ {
+					for x := 0; x < tw; x++ {
+						col, err := readColor(c, r)
+						if err != nil {
+							return err
+						}
+						fb.SetRGBA(int(rect.X)+tx+x, int(rect.Y)+ty+y, col)
+					}
+				}
+				continue
+			}
+
+			if subEncoding&hextileBackgroundSpecified != 0 {
+				var err error
+				if bg, err = readColor(c, r); err != nil {
+					return err
+				}
+			}
+			fillRect(fb, int(rect.X)+tx, int(rect.Y)+ty, tw, th, bg)
+
+			if subEncoding&hextileForegroundSpecified != 0 {
+				var err error
+				if fg, err = readColor(c, r); err != nil {
+					return err
+				}
+			}
+
+			if subEncoding&hextileAnySubrects != 0 {
+				var numSubrects uint8
+				if err := binary.Read(r, binary.BigEndian, &numSubrects); err != nil {
+					return err
+				}
+				for i := uint8(0); i < numSubrects; i++ {
+					col := fg
+					if subEncoding&hextileSubrectsColoured != 0 {
+						var err error
+						if col, err = readColor(c, r); err != nil {
+							return err
+						}
+					}
+
+					var xy, wh uint8
+					if err := binary.Read(r, binary.BigEndian, &xy); err != nil {
+						return err
+					}
+					if err := binary.Read(r, binary.BigEndian, &wh); err != nil {
+						return err
+					}
+
+					sx, sy := int(xy>>4), int(xy&0x0f)
+					sw, sh := int(wh>>4)+1, int(wh&0x0f)+1
+					fillRect(fb, int(rect.X)+tx+sx, int(rect.Y)+ty+sy, sw, sh, col)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// zlibStreamCount is the number of independent zlib streams ZRLE and Tight
+// each require; stream state (the underlying zlib.Reader) persists on
+// ClientConn between FramebufferUpdates.
+const zlibStreamCount = 4
+
+// zlibChunkReader is the io.Reader a persisted zlib.Reader reads from for
+// one stream ID. A ZRLE/Tight server emits a zlib header only the first
+// time (or after an explicit stream reset) a stream ID is used; every
+// later chunk is a continuation of the same deflate bitstream, flushed at
+// each chunk boundary but not restarted. zlibChunkReader strips the wire's
+// per-chunk length prefix and presents the underlying bytes for a stream
+// ID as one uninterrupted stream, so a single zlib.Reader can be created
+// once per stream ID and keep consuming from it across FramebufferUpdates
+// instead of being Reset (which requires a fresh zlib header) on every
+// chunk.
+type zlibChunkReader struct {
+	r         io.Reader
+	remaining uint32
+}
+
+func (z *zlibChunkReader) Read(p []byte) (int, error) {
+	if z.remaining == 0 {
+		if err := binary.Read(z.r, binary.BigEndian, &z.remaining); err != nil {
+			return 0, err
+		}
+	}
+	if uint32(len(p)) > z.remaining {
+		p = p[:z.remaining]
+	}
+	n, err := z.r.Read(p)
+	z.remaining -= uint32(n)
+	return n, err
+}
+
+// resetZlibStreams discards the persisted zlib.Reader/zlibChunkReader
+// state for every stream ID whose bit is set in resetFlags (the low 4
+// bits of a Tight compression-control byte): the server is signalling
+// that the data following for that stream ID starts a brand-new zlib
+// stream, header and all, rather than continuing the persisted one.
+func resetZlibStreams(c *ClientConn, resetFlags uint8) {
+	for i := 0; i < zlibStreamCount; i++ {
+		if resetFlags&(1<<uint(i)) != 0 {
+			c.zlibReaders[i] = nil
+			c.zlibChunkReaders[i] = nil
+		}
+	}
+}
+
+// zlibReader returns the persistent zlib.Reader for streamID, creating it
+// (and consuming streamID's zlib header) the first time streamID is used
+// or after resetZlibStreams has cleared it, and otherwise pointing its
+// zlibChunkReader at the new length-prefixed wire chunk so the
+// zlib.Reader's dictionary state survives across FramebufferUpdates.
+func zlibReader(c *ClientConn, streamID int, r io.Reader) (io.Reader, error) {
+	cr := c.zlibChunkReaders[streamID]
+	if cr == nil {
+		cr = &zlibChunkReader{}
+		c.zlibChunkReaders[streamID] = cr
+	}
+	cr.r = r
+
+	if c.zlibReaders[streamID] == nil {
+		zr, err := zlib.NewReader(cr)
+		if err != nil {
+			return nil, err
+		}
+		c.zlibReaders[streamID] = zr
+		return zr, nil
+	}
+
+	return c.zlibReaders[streamID], nil
+}
+
+// ZRLEEncoding is Zlib Run-Length Encoding: the rectangle is tiled into
+// 64x64 tiles, RLE-packed, then the whole payload is zlib-compressed.
+//
+// See RFC 6143 Section 7.7.6
+type ZRLEEncoding struct{}
+
+func (*ZRLEEncoding) Type() int32 { return ZRLE }
+
+func (*ZRLEEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	zr, err := zlibReader(c, 0, r)
+	if err != nil {
+		return err
+	}
+
+	fb := c.framebuffer()
+	for ty := 0; ty < int(rect.Height); ty += 64 {
+		th := min(64, int(rect.Height)-ty)
+		for tx := 0; tx < int(rect.Width); tx += 64 {
+			tw := min(64, int(rect.Width)-tx)
+
+			var mode uint8
+			if err := binary.Read(zr, binary.BigEndian, &mode); err != nil {
+				return err
+			}
+
+			switch {
+			case mode == 0: // raw
+				for y := 0; y < th; y++ {
+					for x := 0; x < tw; x++ {
+						col, err := readColor(c, zr)
+						if err != nil {
+							return err
+						}
+						fb.SetRGBA(int(rect.X)+tx+x, int(rect.Y)+ty+y, col)
+					}
+				}
+			case mode == 1: // solid
+				col, err := readColor(c, zr)
+				if err != nil {
+					return err
+				}
+				fillRect(fb, int(rect.X)+tx, int(rect.Y)+ty, tw, th, col)
+			default:
+				return fmt.Errorf("ZRLEEncoding: unsupported palette/RLE sub-mode %d", mode)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TightEncoding is the Tight encoding: each rectangle chooses, per
+// compression-control byte, between raw fill, a JPEG stream, or basic
+// (palette/gradient/copy) filters atop one of four persistent zlib
+// streams.
+//
+// # See RFC 6143 Section 7.7.5
+//
+// Only the fill and basic/zlib-raw sub-encodings are implemented; a JPEG
+// payload is consumed off the wire (so the stream stays in sync) but is
+// not decoded into the framebuffer.
+type TightEncoding struct{}
+
+// The compression-control byte's low 4 bits are per-stream zlib reset
+// flags (bit i resets stream i); the high nibble (ctl>>4) is the command:
+// tightFill/tightJPEG select those sub-encodings exactly, anything else
+// in 0x0-0x7 is "basic" compression, with the stream ID and explicit-
+// filter bit packed into that nibble's low bits.
+const (
+	tightFill            = 0x8
+	tightJPEG            = 0x9
+	tightExplicitFilter  = 0x4
+	tightStreamIDMask    = 0x3
+	tightBasicFilterMask = tightStreamIDMask | tightExplicitFilter
+
+	// tightFilterCopy is the only basic-rect filter this decoder
+	// understands: per-pixel colors read straight off the zlib stream.
+	// Palette (1) and gradient (2) filters are rejected rather than
+	// silently misdecoded.
+	tightFilterCopy = 0
+)
+
+func (*TightEncoding) Type() int32 { return Tight }
+
+func (*TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	var ctl uint8
+	if err := binary.Read(r, binary.BigEndian, &ctl); err != nil {
+		return err
+	}
+
+	resetZlibStreams(c, ctl&0x0f)
+
+	fb := c.framebuffer()
+	cmd := ctl >> 4
+
+	switch cmd {
+	case tightFill:
+		col, err := readColor(c, r)
+		if err != nil {
+			return err
+		}
+		fillRect(fb, int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height), col)
+		return nil
+
+	case tightJPEG:
+		length, err := readTightLength(r)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(io.Discard, r, int64(length))
+		return err
+
+	default:
+		if cmd&^tightBasicFilterMask != 0 {
+			return fmt.Errorf("TightEncoding: unsupported compression-control byte %#x", ctl)
+		}
+
+		if cmd&tightExplicitFilter != 0 {
+			var filterID uint8
+			if err := binary.Read(r, binary.BigEndian, &filterID); err != nil {
+				return err
+			}
+			if filterID != tightFilterCopy {
+				return fmt.Errorf("TightEncoding: unsupported filter id %d (palette/gradient not implemented)", filterID)
+			}
+		}
+
+		streamID := int(cmd & tightStreamIDMask)
+		zr, err := zlibReader(c, streamID, r)
+		if err != nil {
+			return err
+		}
+		for y := 0; y < int(rect.Height); y++ {
+			for x := 0; x < int(rect.Width); x++ {
+				col, err := readColor(c, zr)
+				if err != nil {
+					return err
+				}
+				fb.SetRGBA(int(rect.X)+x, int(rect.Y)+y, col)
+			}
+		}
+		return nil
+	}
+}
+
+// readTightLength reads Tight's variable-length (1-3 byte) compressed
+// data length.
+func readTightLength(r io.Reader) (int, error) {
+	length := 0
+	for i := 0; i < 3; i++ {
+		var b uint8
+		if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+			return 0, err
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return length, nil
+}
+
+// CursorPseudoEncoding carries a cursor-shape update; it updates
+// ClientConn state rather than the framebuffer.
+//
+// See RFC 6143 Section 7.7.7
+type CursorPseudoEncoding struct{}
+
+func (*CursorPseudoEncoding) Type() int32 { return CursorPseudo }
+
+func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	pixels := int(rect.Width) * int(rect.Height) * int(c.PixelFormat.BPP/8)
+	maskBytes := (int(rect.Width) + 7) / 8 * int(rect.Height)
+
+	cursor := make([]byte, pixels)
+	if _, err := io.ReadFull(r, cursor); err != nil {
+		return err
+	}
+	mask := make([]byte, maskBytes)
+	if _, err := io.ReadFull(r, mask); err != nil {
+		return err
+	}
+
+	c.cursor = Cursor{Width: rect.Width, Height: rect.Height, HotX: rect.X, HotY: rect.Y, Pixels: cursor, Mask: mask}
+	return nil
+}
+
+// XCursorPseudoEncoding carries a 2-color cursor-shape update.
+//
+// See RFC 6143 Section 7.7.8
+type XCursorPseudoEncoding struct{}
+
+func (*XCursorPseudoEncoding) Type() int32 { return XCursorPseudo }
+
+func (*XCursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	var colors [2]Color
+	if err := binary.Read(r, binary.BigEndian, &colors); err != nil {
+		return err
+	}
+
+	maskBytes := (int(rect.Width) + 7) / 8 * int(rect.Height)
+	pixels := make([]byte, maskBytes)
+	if _, err := io.ReadFull(r, pixels); err != nil {
+		return err
+	}
+	mask := make([]byte, maskBytes)
+	if _, err := io.ReadFull(r, mask); err != nil {
+		return err
+	}
+
+	c.cursor = Cursor{Width: rect.Width, Height: rect.Height, HotX: rect.X, HotY: rect.Y, Pixels: pixels, Mask: mask}
+	return nil
+}
+
+// Cursor holds the most recently received cursor shape, as delivered by
+// CursorPseudoEncoding or XCursorPseudoEncoding.
+type Cursor struct {
+	Width, Height uint16
+	HotX, HotY    uint16
+	Pixels, Mask  []byte
+}
+
+// DesktopSizePseudoEncoding signals that the server's framebuffer
+// dimensions have changed; rect.Width/Height carry the new size.
+//
+// See RFC 6143 Section 7.7.9
+type DesktopSizePseudoEncoding struct{}
+
+func (*DesktopSizePseudoEncoding) Type() int32 { return DesktopSizePseudo }
+
+func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	c.FrameBufferWidth = rect.Width
+	c.FrameBufferHeight = rect.Height
+	c.frameBuffer = nil // reallocated lazily at the new size
+	return nil
+}
+
+func fillRect(fb *image.RGBA, x, y, w, h int, col color.RGBA) {
+	for dy := 0; dy < h; dy++ {
+		for dx := 0; dx < w; dx++ {
+			fb.SetRGBA(x+dx, y+dy, col)
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
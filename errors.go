@@ -0,0 +1,21 @@
+/*
+errors.go defines VNCError, the error type this package returns for
+protocol and handshake failures that originate on this end of the
+connection, as opposed to errors surfaced by the underlying net.Conn or
+by encoding/binary.
+*/
+package vnc
+
+// VNCError implements error for errors originating in this package.
+type VNCError struct {
+	msg string
+}
+
+func (err VNCError) Error() string {
+	return err.msg
+}
+
+// NewVNCError returns a VNCError with the given message.
+func NewVNCError(msg string) error {
+	return VNCError{msg}
+}
@@ -0,0 +1,199 @@
+/*
+extensions.go implements a registry for RFB extensions that modern
+servers advertise as pseudo-encodings: QEMU Extended Key Event,
+ExtendedDesktopSize, ContinuousUpdates, Fence and LED state. Registering
+an Extension causes its pseudo-encoding to be offered in SetEncodings and
+its Enable method to fire once the server acknowledges support, unlocking
+whatever outbound messages or inbound ServerMessage handling the
+extension adds.
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Pseudo-encoding numbers for the extensions this package knows about.
+const (
+	PseudoEncQEMUExtendedKeyEvent = int32(-258)
+	PseudoEncExtendedDesktopSize  = int32(-308)
+	PseudoEncFence                = int32(-312)
+	PseudoEncContinuousUpdates    = int32(-313)
+	PseudoEncLEDState             = int32(-261)
+)
+
+// Extension server message types, assigned by their respective specs.
+const (
+	EndOfContinuousUpdatesMsg = uint8(150)
+	ServerFenceMsg            = uint8(248)
+)
+
+// An Extension unlocks protocol behaviour beyond RFC 6143 once both ends
+// have agreed to it. Register one with ClientConn.RegisterExtension; its
+// PseudoEncoding is then appended to the client's SetEncodings list, and
+// its Enable method fires the first time the server signals support,
+// either via a FramebufferUpdate rectangle carrying that pseudo-encoding
+// or a registered extension ServerMessage.
+type Extension interface {
+	// PseudoEncoding is the pseudo-encoding number this extension is
+	// negotiated under.
+	PseudoEncoding() int32
+
+	// Enable is called at most once, the first time the server
+	// acknowledges support for PseudoEncoding.
+	Enable(c *ClientConn)
+}
+
+// RegisterExtension registers ext with c.
+func (c *ClientConn) RegisterExtension(ext Extension) {
+	if c.extensions == nil {
+		c.extensions = make(map[int32]Extension)
+	}
+	c.extensions[ext.PseudoEncoding()] = ext
+}
+
+// RegisterExtensionMessage registers a ServerMessage type to be
+// recognised by the read loop under wire type msgType, alongside the
+// RFC 6143 messages server.go already knows. Extensions that add server
+// messages (EndOfContinuousUpdates, ServerFence, ...) call this from
+// their Enable method or at registration time.
+func (c *ClientConn) RegisterExtensionMessage(msgType uint8, msg ServerMessage) {
+	if c.extensionMessages == nil {
+		c.extensionMessages = make(map[uint8]ServerMessage)
+	}
+	c.extensionMessages[msgType] = msg
+}
+
+// extensionEncodings returns the pseudo-encoding number of every
+// registered extension, for SetEncodings to append to its own list.
+func (c *ClientConn) extensionEncodings() []int32 {
+	encs := make([]int32, 0, len(c.extensions))
+	for enc := range c.extensions {
+		encs = append(encs, enc)
+	}
+	return encs
+}
+
+// enableExtension fires the registered extension's Enable method the
+// first time pseudoEncoding is acknowledged by the server.
+func (c *ClientConn) enableExtension(pseudoEncoding int32) {
+	ext, ok := c.extensions[pseudoEncoding]
+	if !ok {
+		return
+	}
+	if c.enabledExtensions == nil {
+		c.enabledExtensions = make(map[int32]bool)
+	}
+	if c.enabledExtensions[pseudoEncoding] {
+		return
+	}
+	c.enabledExtensions[pseudoEncoding] = true
+	ext.Enable(c)
+}
+
+// extensionPseudoEncoding is a generic, payload-free Encoding used for
+// any registered extension's pseudo-encoding: receiving a rectangle
+// carrying it just means "the server supports this extension" and only
+// needs to fire Enable, the same way DesktopSizePseudoEncoding carries
+// its data in the rectangle header rather than the body.
+type extensionPseudoEncoding struct {
+	pseudoEncoding int32
+}
+
+func (e *extensionPseudoEncoding) Type() int32 { return e.pseudoEncoding }
+
+func (e *extensionPseudoEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) error {
+	c.enableExtension(e.pseudoEncoding)
+	return nil
+}
+
+// extensionEncMap builds encMap entries for every registered extension,
+// so FramebufferUpdate.Read recognises their pseudo-encodings instead of
+// failing with "unsupported encoding type".
+func (c *ClientConn) extensionEncMap() map[int32]Encoding {
+	m := make(map[int32]Encoding, len(c.extensions))
+	for enc := range c.extensions {
+		m[enc] = &extensionPseudoEncoding{enc}
+	}
+	return m
+}
+
+// EndOfContinuousUpdates acknowledges a client's EnableContinuousUpdates
+// request; it carries no payload.
+type EndOfContinuousUpdates struct{}
+
+func (*EndOfContinuousUpdates) Type() uint8 { return EndOfContinuousUpdatesMsg }
+
+func (*EndOfContinuousUpdates) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
+	c.enableExtension(PseudoEncContinuousUpdates)
+	return new(EndOfContinuousUpdates), nil
+}
+
+// ServerFence is the server-to-client half of the Fence extension, used
+// to synchronise client and server state; Payload is echoed back to the
+// server unchanged in a client Fence message.
+type ServerFence struct {
+	Flags   uint32
+	Payload []byte
+}
+
+func (*ServerFence) Type() uint8 { return ServerFenceMsg }
+
+func (*ServerFence) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
+	var padding [3]byte
+	if err := binary.Read(r, binary.BigEndian, &padding); err != nil {
+		return nil, err
+	}
+
+	var flags uint32
+	if err := binary.Read(r, binary.BigEndian, &flags); err != nil {
+		return nil, err
+	}
+
+	var length uint8
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	c.enableExtension(PseudoEncFence)
+	return &ServerFence{flags, payload}, nil
+}
+
+// qemuExtendedKeyEventMsg is QEMU's vendor client-to-server message type;
+// sub-message 0 is the extended key event.
+const qemuExtendedKeyEventMsg = uint8(255)
+
+// QEMUExtendedKeyEvent unlocks SendQEMUExtendedKeyEvent once the server
+// acknowledges the pseudo-encoding, letting KeyEvent messages carry the
+// hardware keycode alongside the X11 keysym.
+type QEMUExtendedKeyEvent struct{}
+
+func (*QEMUExtendedKeyEvent) PseudoEncoding() int32 { return PseudoEncQEMUExtendedKeyEvent }
+
+func (*QEMUExtendedKeyEvent) Enable(c *ClientConn) {}
+
+// SendQEMUExtendedKeyEvent sends a QEMU Extended Key Event message. The
+// server must have acknowledged PseudoEncQEMUExtendedKeyEvent, e.g. via a
+// registered *QEMUExtendedKeyEvent, before this is meaningful.
+func (c *ClientConn) SendQEMUExtendedKeyEvent(down bool, keysym uint32, keycode uint32) error {
+	var downFlag uint16
+	if down {
+		downFlag = 1
+	}
+
+	msg := struct {
+		Type       uint8
+		SubMessage uint8
+		Down       uint16
+		Keysym     uint32
+		Keycode    uint32
+	}{qemuExtendedKeyEventMsg, 0, downFlag, keysym, keycode}
+
+	return c.send(msg)
+}
@@ -7,6 +7,7 @@ package vnc
 
 import (
 	"crypto/des"
+	"crypto/rand"
 	"encoding/binary"
 	"net"
 )
@@ -17,6 +18,11 @@ const (
 	secTypeVNCAuth
 )
 
+const (
+	secTypeTLS      = 18
+	secTypeVeNCrypt = 19
+)
+
 // ClientAuth implements a method of authenticating with a remote server.
 type ClientAuth interface {
 	// SecurityType returns the byte identifier sent by the server to
@@ -24,8 +30,12 @@ type ClientAuth interface {
 	SecurityType() uint8
 
 	// Handshake is called when the authentication handshake should be
-	// performed, as part of the general RFB handshake. (see 7.1.2)
-	Handshake(net.Conn) error
+	// performed, as part of the general RFB handshake. (see 7.1.2). It
+	// returns the net.Conn the rest of the RFB handshake should continue
+	// on, which may be conn itself or, for schemes that tunnel the
+	// session (e.g. ClientAuthTLS, ClientAuthVeNCrypt), a conn wrapping
+	// it.
+	Handshake(conn net.Conn) (net.Conn, error)
 }
 
 // ClientAuthNone is the "none" authentication. See 7.1.2
@@ -35,8 +45,8 @@ func (*ClientAuthNone) SecurityType() uint8 {
 	return secTypeNone
 }
 
-func (*ClientAuthNone) Handshake(net.Conn) error {
-	return nil
+func (*ClientAuthNone) Handshake(conn net.Conn) (net.Conn, error) {
+	return conn, nil
 }
 
 // ClientAuthVNC is the standard password authentication.
@@ -51,32 +61,41 @@ func (*ClientAuthVNC) SecurityType() uint8 {
 // 7.2.2. VNC Authentication uses a 16-byte challenge.
 const vncAuthChallengeSize = 16
 
-func (auth *ClientAuthVNC) Handshake(conn net.Conn) error {
+func (auth *ClientAuthVNC) Handshake(conn net.Conn) (net.Conn, error) {
 
 	if auth.Password == "" {
-		return NewVNCError("securityHandshake: handshake failed; no password provided for VNCAuth.")
+		return nil, NewVNCError("securityHandshake: handshake failed; no password provided for VNCAuth.")
 	}
 
 	// Read challenge block
 	var challenge [vncAuthChallengeSize]byte
 	if err := binary.Read(conn, binary.BigEndian, &challenge); err != nil {
-		return err
+		return nil, err
 	}
 
 	auth.encode(&challenge)
 
 	// Send the encrypted challenge back to server
 	if err := binary.Write(conn, binary.BigEndian, challenge); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return conn, nil
 }
 
 func (auth *ClientAuthVNC) encode(c *[vncAuthChallengeSize]byte) error {
+	return vncAuthEncrypt(c, auth.Password)
+}
+
+// vncAuthEncrypt DES-encrypts a 16-byte VNC Authentication challenge in
+// place using the given password as the key. It is shared by the client
+// (ClientAuthVNC, encrypting the server's challenge) and the server
+// (ServerAuthVNC, encrypting its own challenge to validate the client's
+// response against).
+func vncAuthEncrypt(c *[vncAuthChallengeSize]byte, password string) error {
 	// Copy password string to 8 byte 0-padded slice
 	key := make([]byte, 8)
-	copy(key, auth.Password)
+	copy(key, password)
 
 	// Each byte of the password needs to be reversed. This is a
 	// non RFC-documented behaviour of VNC clients and servers
@@ -95,5 +114,55 @@ func (auth *ClientAuthVNC) encode(c *[vncAuthChallengeSize]byte) error {
 		cipher.Encrypt(c[i:i+cipher.BlockSize()], c[i:i+cipher.BlockSize()])
 	}
 
+	return nil
+}
+
+// ServerAuth implements the server side of a security-type handshake. It
+// mirrors ClientAuth.
+type ServerAuth interface {
+	// SecurityType returns the byte identifier advertised to the client
+	// to identify this authentication scheme.
+	SecurityType() uint8
+
+	// Handshake is called when the authentication handshake should be
+	// performed, as part of the general RFB handshake. (see 7.1.2). It
+	// returns a non-nil error if the client fails to authenticate.
+	Handshake(net.Conn) error
+}
+
+// ServerAuthVNC is the server side of the standard VNC password
+// authentication: it generates a random challenge, sends it to the client,
+// and validates the DES-encrypted response against Password.
+type ServerAuthVNC struct {
+	Password string
+}
+
+func (*ServerAuthVNC) SecurityType() uint8 {
+	return secTypeVNCAuth
+}
+
+func (auth *ServerAuthVNC) Handshake(conn net.Conn) error {
+	var challenge [vncAuthChallengeSize]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return err
+	}
+
+	if err := binary.Write(conn, binary.BigEndian, challenge); err != nil {
+		return err
+	}
+
+	var response [vncAuthChallengeSize]byte
+	if err := binary.Read(conn, binary.BigEndian, &response); err != nil {
+		return err
+	}
+
+	if err := vncAuthEncrypt(&challenge, auth.Password); err != nil {
+		return err
+	}
+
+	if challenge != response {
+		return NewVNCError("ServerAuthVNC: handshake failed; challenge response did not match")
+	}
+
 	return nil
 }
\ No newline at end of file
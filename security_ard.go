@@ -0,0 +1,132 @@
+package vnc
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+)
+
+const secTypeARD = 30
+
+// ClientAuthARD implements security type 30: Apple Remote Desktop
+// authentication, as used by macOS Screen Sharing. It performs a
+// Diffie-Hellman key exchange with the server, derives an AES-128 key
+// from the shared secret, and sends the username/password encrypted
+// under that key.
+type ClientAuthARD struct {
+	Username, Password string
+}
+
+func (*ClientAuthARD) SecurityType() uint8 {
+	return secTypeARD
+}
+
+// ardCredentialsSize is the size, in bytes, of the encrypted
+// username/password block ARD exchanges; RFC 6143 does not cover this
+// extension, so the layout below follows Apple's (undocumented but
+// widely reverse-engineered) wire format.
+const ardCredentialsSize = 128
+
+func (auth *ClientAuthARD) Handshake(conn net.Conn) (net.Conn, error) {
+	var generator uint16
+	if err := binary.Read(conn, binary.BigEndian, &generator); err != nil {
+		return nil, err
+	}
+
+	var keyLength uint16
+	if err := binary.Read(conn, binary.BigEndian, &keyLength); err != nil {
+		return nil, err
+	}
+
+	prime := make([]byte, keyLength)
+	if _, err := io.ReadFull(conn, prime); err != nil {
+		return nil, err
+	}
+	serverPublicKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(conn, serverPublicKey); err != nil {
+		return nil, err
+	}
+
+	p := new(big.Int).SetBytes(prime)
+	g := big.NewInt(int64(generator))
+	serverPub := new(big.Int).SetBytes(serverPublicKey)
+
+	privateKey, err := rand.Int(rand.Reader, p)
+	if err != nil {
+		return nil, err
+	}
+
+	clientPub := new(big.Int).Exp(g, privateKey, p)
+	sharedSecret := new(big.Int).Exp(serverPub, privateKey, p)
+
+	key := md5.Sum(leftPad(sharedSecret.Bytes(), int(keyLength)))
+
+	creds, err := ardCredentials(auth.Username, auth.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(creds); i += cipher.BlockSize() {
+		cipher.Encrypt(creds[i:i+cipher.BlockSize()], creds[i:i+cipher.BlockSize()])
+	}
+
+	clientPubKey := leftPad(clientPub.Bytes(), int(keyLength))
+
+	if _, err := conn.Write(creds); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(clientPubKey); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// ardCredentials builds the fixed-size ARD credentials block: a
+// NUL-terminated username, a NUL-terminated password, and random padding
+// filling out the remaining bytes.
+func ardCredentials(username, password string) ([]byte, error) {
+	block := make([]byte, ardCredentialsSize)
+	if _, err := rand.Read(block); err != nil {
+		return nil, err
+	}
+
+	writeField := func(offset int, s string) error {
+		if len(s)+1 > ardCredentialsSize/2 {
+			return NewVNCError("ClientAuthARD: username or password too long")
+		}
+		copy(block[offset:], s)
+		block[offset+len(s)] = 0
+		return nil
+	}
+
+	if err := writeField(0, username); err != nil {
+		return nil, err
+	}
+	if err := writeField(ardCredentialsSize/2, password); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// leftPad pads b with leading zero bytes up to size, as required when a
+// big.Int's minimal byte representation is shorter than the fixed DH key
+// length.
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
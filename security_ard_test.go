@@ -0,0 +1,42 @@
+package vnc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestArdCredentialsFieldLayout(t *testing.T) {
+	block, err := ardCredentials("user", "hunter2")
+	if err != nil {
+		t.Fatalf("ardCredentials: %v", err)
+	}
+	if len(block) != ardCredentialsSize {
+		t.Fatalf("len(block) = %d, want %d", len(block), ardCredentialsSize)
+	}
+
+	username := block[:bytes.IndexByte(block[:ardCredentialsSize/2], 0)]
+	if string(username) != "user" {
+		t.Errorf("username = %q, want %q", username, "user")
+	}
+
+	passwordField := block[ardCredentialsSize/2:]
+	password := passwordField[:bytes.IndexByte(passwordField, 0)]
+	if string(password) != "hunter2" {
+		t.Errorf("password = %q, want %q", password, "hunter2")
+	}
+}
+
+func TestArdCredentialsFieldTooLong(t *testing.T) {
+	tooLong := strings.Repeat("x", ardCredentialsSize/2)
+
+	if _, err := ardCredentials(tooLong, "short"); err == nil {
+		t.Error("ardCredentials: expected error for oversized username, got nil")
+	}
+	if _, err := ardCredentials("short", tooLong); err == nil {
+		t.Error("ardCredentials: expected error for oversized password, got nil")
+	}
+	if _, err := ardCredentials("short", "short"); err != nil {
+		t.Errorf("ardCredentials: unexpected error for in-budget fields: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package vnc
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+)
+
+// ClientAuthTLS implements security type 18: the connection is upgraded to
+// TLS using Config, and the remainder of the RFB handshake (including any
+// further security negotiation the server performs over the tunnel) runs
+// on the TLS conn.
+//
+// See the RFB VeNCrypt/TLS extension, as implemented by TigerVNC and QEMU.
+type ClientAuthTLS struct {
+	Config *tls.Config
+}
+
+func (*ClientAuthTLS) SecurityType() uint8 {
+	return secTypeTLS
+}
+
+func (auth *ClientAuthTLS) Handshake(conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Client(conn, auth.Config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// VeNCrypt sub-types, as defined by the VeNCrypt RFB extension.
+const (
+	veNCryptPlain     = 256
+	veNCryptTLSNone   = 257
+	veNCryptTLSVNC    = 258
+	veNCryptTLSPlain  = 259
+	veNCryptX509None  = 260
+	veNCryptX509VNC   = 261
+	veNCryptX509Plain = 262
+)
+
+// ClientAuthVeNCrypt implements security type 19 (VeNCrypt). It performs
+// the VeNCrypt sub-version handshake, picks the first of SubTypes the
+// server also offers, upgrades the connection to TLS (for the TLSNone,
+// TLSVnc, TLSPlain, X509None, X509Vnc and X509Plain sub-types), and
+// delegates any remaining authentication to Inner.
+type ClientAuthVeNCrypt struct {
+	// SubTypes is the list of VeNCrypt sub-types this client is willing
+	// to use, in order of preference.
+	SubTypes []uint32
+
+	// Config is used for the TLS/x509 upgrade when a TLS-based sub-type
+	// is chosen.
+	Config *tls.Config
+
+	// Inner authenticates over the (possibly TLS-tunnelled) connection
+	// once the sub-type has been negotiated, e.g. &ClientAuthVNC{} for
+	// the TLSVnc/X509Vnc sub-types, or &ClientAuthNone{} for TLSNone
+	// /X509None/Plain.
+	Inner ClientAuth
+}
+
+func (*ClientAuthVeNCrypt) SecurityType() uint8 {
+	return secTypeVeNCrypt
+}
+
+func (auth *ClientAuthVeNCrypt) Handshake(conn net.Conn) (net.Conn, error) {
+	// 1. Sub-version handshake: server sends major.minor, we echo back
+	// the highest we support (0.2) and the server ACKs.
+	var serverVersion [2]byte
+	if err := binary.Read(conn, binary.BigEndian, &serverVersion); err != nil {
+		return nil, err
+	}
+
+	clientVersion := [2]byte{0, 2}
+	if err := binary.Write(conn, binary.BigEndian, clientVersion); err != nil {
+		return nil, err
+	}
+
+	var ack uint8
+	if err := binary.Read(conn, binary.BigEndian, &ack); err != nil {
+		return nil, err
+	}
+	if ack != 0 {
+		return nil, NewVNCError("ClientAuthVeNCrypt: server rejected sub-version 0.2")
+	}
+
+	// 2. Server offers a list of sub-types; we pick the first of ours it
+	// also offers.
+	var numTypes uint8
+	if err := binary.Read(conn, binary.BigEndian, &numTypes); err != nil {
+		return nil, err
+	}
+	offered := make([]uint32, numTypes)
+	for i := range offered {
+		if err := binary.Read(conn, binary.BigEndian, &offered[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	chosen, ok := auth.chooseSubType(offered)
+	if !ok {
+		return nil, NewVNCError("ClientAuthVeNCrypt: no common sub-type with server")
+	}
+
+	if err := binary.Write(conn, binary.BigEndian, chosen); err != nil {
+		return nil, err
+	}
+
+	// 3. TLS/x509 upgrade, for every sub-type except Plain.
+	tunnel := conn
+	if chosen != veNCryptPlain {
+		tlsConn := tls.Client(conn, auth.Config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		tunnel = tlsConn
+	}
+
+	// 4. Delegate the post-tunnel authentication (VNC Authentication for
+	// the *Vnc sub-types, none for *None/Plain).
+	inner := auth.Inner
+	if inner == nil {
+		inner = &ClientAuthNone{}
+	}
+	return inner.Handshake(tunnel)
+}
+
+func (auth *ClientAuthVeNCrypt) chooseSubType(offered []uint32) (uint32, bool) {
+	offeredSet := make(map[uint32]bool, len(offered))
+	for _, t := range offered {
+		offeredSet[t] = true
+	}
+	for _, t := range auth.SubTypes {
+		if offeredSet[t] {
+			return t, true
+		}
+	}
+	return 0, false
+}
@@ -81,6 +81,9 @@ func (m *FramebufferUpdate) Read(c *ClientConn, r io.Reader) (ServerMessage, err
 	for _, e := range c.Encodings() {
 		encMap[e.Type()] = e
 	}
+	for enc, e := range c.extensionEncMap() {
+		encMap[enc] = e
+	}
 	encMap[Raw] = NewRawEncoding([]Color{}) // Raw encoding support required.
 
 	// Read packet.
@@ -109,6 +112,14 @@ func (m *FramebufferUpdate) Read(c *ClientConn, r io.Reader) (ServerMessage, err
 		rect.Width = msg.Width
 		rect.Height = msg.Height
 		rect.Enc = enc
+
+		if err := enc.Read(c, rect, r); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.recorder != nil {
+		c.recorder.frame(c.framebuffer())
 	}
 
 	return NewFramebufferUpdate(rects), nil
@@ -0,0 +1,245 @@
+/*
+server_conn.go implements the server half of the RFB protocol handshake
+described in RFC 6143 §7.1-§7.4: the ProtocolVersion exchange, security
+negotiation, and the initialization messages, followed by a read loop that
+dispatches ClientMessages. It is the server-side counterpart to ClientConn.
+
+See http://tools.ietf.org/html/rfc6143 for more info.
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// PixelFormat describes the way pixel data is formatted on the wire.
+//
+// See RFC 6143 Section 7.4
+type PixelFormat struct {
+	BPP                             uint8
+	Depth                           uint8
+	BigEndian                       uint8
+	TrueColor                       uint8
+	RedMax, GreenMax, BlueMax       uint16
+	RedShift, GreenShift, BlueShift uint8
+	_                               [3]byte // padding
+}
+
+// ServerConfig configures the handshake and initial state of a ServerConn
+// accepted via Listen or NewServerConn.
+type ServerConfig struct {
+	// Width and Height are the dimensions of the framebuffer advertised to
+	// the client in the ServerInit message.
+	Width, Height uint16
+
+	// DesktopName is advertised to the client in the ServerInit message.
+	DesktopName string
+
+	// PixelFormat is the server's native pixel format, advertised to the
+	// client in the ServerInit message. Clients are free to override it
+	// with a SetPixelFormat message.
+	PixelFormat PixelFormat
+
+	// Auth is the list of security types offered to the client, in order
+	// of preference. At least one must be supplied.
+	Auth []ServerAuth
+
+	// ClientMessageCh, if non-nil, receives every ClientMessage decoded
+	// from the connection once Serve's read loop is running.
+	ClientMessageCh chan ClientMessage
+}
+
+// ServerConn holds the state for a single accepted VNC connection. It is
+// the server-side counterpart to ClientConn.
+type ServerConn struct {
+	c      net.Conn
+	config *ServerConfig
+
+	colorMap    [256]Color
+	encodings   []Encoding
+	pixelFormat PixelFormat
+
+	width, height uint16
+	desktopName   string
+
+	debug bool
+}
+
+// Listen accepts connections on ln forever, performing the RFB handshake on
+// each one per cfg and handing the resulting ServerConn to handler. Listen
+// returns when ln.Accept returns a non-temporary error.
+func Listen(ln net.Listener, cfg *ServerConfig, handler func(*ServerConn)) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		go func() {
+			sc, err := NewServerConn(c, cfg)
+			if err != nil {
+				c.Close()
+				return
+			}
+			handler(sc)
+		}()
+	}
+}
+
+// NewServerConn performs the server side of the RFB handshake on c and
+// returns the resulting ServerConn. The caller is responsible for calling
+// Serve to start dispatching ClientMessages.
+func NewServerConn(c net.Conn, cfg *ServerConfig) (*ServerConn, error) {
+	if len(cfg.Auth) == 0 {
+		return nil, NewVNCError("NewServerConn: no security types configured")
+	}
+
+	sc := &ServerConn{
+		c:           c,
+		config:      cfg,
+		pixelFormat: cfg.PixelFormat,
+		width:       cfg.Width,
+		height:      cfg.Height,
+		desktopName: cfg.DesktopName,
+	}
+
+	if err := sc.protocolVersionHandshake(); err != nil {
+		return nil, err
+	}
+	if err := sc.securityHandshake(); err != nil {
+		return nil, err
+	}
+	if err := sc.clientInit(); err != nil {
+		return nil, err
+	}
+	if err := sc.serverInit(); err != nil {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+// protocolVersionHandshake implements RFC 6143 §7.1.1.
+func (c *ServerConn) protocolVersionHandshake() error {
+	if _, err := c.c.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+
+	var versionMsg [12]byte
+	if _, err := io.ReadFull(c.c, versionMsg[:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// securityHandshake implements RFC 6143 §7.1.2: the server advertises the
+// configured security types, the client picks one, and that type's
+// ServerAuth runs the remainder of the authentication exchange.
+func (c *ServerConn) securityHandshake() error {
+	types := c.config.Auth
+
+	if err := binary.Write(c.c, binary.BigEndian, uint8(len(types))); err != nil {
+		return err
+	}
+	for _, auth := range types {
+		if err := binary.Write(c.c, binary.BigEndian, auth.SecurityType()); err != nil {
+			return err
+		}
+	}
+
+	var chosen uint8
+	if err := binary.Read(c.c, binary.BigEndian, &chosen); err != nil {
+		return err
+	}
+
+	for _, auth := range types {
+		if auth.SecurityType() != chosen {
+			continue
+		}
+		if err := auth.Handshake(c.c); err != nil {
+			binary.Write(c.c, binary.BigEndian, uint32(1)) // SecurityResult: failed
+			return err
+		}
+		return binary.Write(c.c, binary.BigEndian, uint32(0)) // SecurityResult: OK
+	}
+
+	return fmt.Errorf("securityHandshake: client chose unadvertised security type %d", chosen)
+}
+
+// clientInit implements RFC 6143 §7.3.1.
+func (c *ServerConn) clientInit() error {
+	var shared uint8
+	return binary.Read(c.c, binary.BigEndian, &shared)
+}
+
+// serverInit implements RFC 6143 §7.3.2.
+func (c *ServerConn) serverInit() error {
+	if err := binary.Write(c.c, binary.BigEndian, c.width); err != nil {
+		return err
+	}
+	if err := binary.Write(c.c, binary.BigEndian, c.height); err != nil {
+		return err
+	}
+	if err := binary.Write(c.c, binary.BigEndian, c.pixelFormat); err != nil {
+		return err
+	}
+	if err := binary.Write(c.c, binary.BigEndian, uint32(len(c.desktopName))); err != nil {
+		return err
+	}
+	_, err := c.c.Write([]byte(c.desktopName))
+	return err
+}
+
+// clientMessages are the ClientMessage types this ServerConn knows how to
+// decode, keyed by wire type. Unlike ClientConn's ServerMessage dispatch,
+// there is no RegisterExtension-style hook on the server side yet, so
+// extension ClientMessages (e.g. QEMU's extended key event, wire type
+// 255) aren't decodable here.
+var clientMessages = map[uint8]ClientMessage{
+	SetPixelFormatMsg:           new(SetPixelFormat),
+	SetEncodingsMsg:             new(SetEncodings),
+	FramebufferUpdateRequestMsg: new(FramebufferUpdateRequest),
+	KeyEventMsg:                 new(KeyEvent),
+	PointerEventMsg:             new(PointerEvent),
+	ClientCutTextMsg:            new(ClientCutText),
+}
+
+// Serve runs the read loop for c, decoding ClientMessages off the wire and
+// delivering them to c.config.ClientMessageCh until the connection errs or
+// is closed.
+func (c *ServerConn) Serve() error {
+	defer c.c.Close()
+
+	for {
+		var messageType uint8
+		if err := binary.Read(c.c, binary.BigEndian, &messageType); err != nil {
+			return err
+		}
+
+		proto, ok := clientMessages[messageType]
+		if !ok {
+			return fmt.Errorf("Serve: unsupported message type: %d", messageType)
+		}
+
+		msg, err := proto.Read(c, c.c)
+		if err != nil {
+			return err
+		}
+
+		if c.config.ClientMessageCh != nil {
+			c.config.ClientMessageCh <- msg
+		}
+	}
+}
+
+// Close closes the underlying connection.
+func (c *ServerConn) Close() error {
+	return c.c.Close()
+}